@@ -0,0 +1,102 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package addrindex reads back the address->txhash appearance index that
+// core/vm.AppearanceIndexer writes alongside parity traces, providing the raw
+// material for an Otterscan-style ots_searchTransactionsBefore/After API.
+package addrindex
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+type appearanceEntry struct {
+	Address     common.Address `json:"address"`
+	TxHash      common.Hash    `json:"txHash"`
+	BlockNumber uint64         `json:"blockNumber"`
+}
+
+// ReadAppearances scans the addr_index shards for blocks in [fromBlock,
+// toBlock] and returns the hash of every transaction addr appeared in, in a
+// block within that range, deduplicated across shards. Since a shard can
+// cover many blocks when vm.AppearanceIndexPerFile > 1, entries are filtered
+// on BlockNumber so that blocks sharing a shard with, but outside, the
+// requested range are not returned. Shards are located using
+// vm.AppearanceIndexPerFolder and vm.AppearanceIndexPerFile; shards that
+// vm.NewAppearanceIndexer wrote with other perFolder/perFile values are not
+// read.
+func ReadAppearances(addr common.Address, fromBlock, toBlock uint64) ([]common.Hash, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("get current work dir failed: %w", err)
+	}
+
+	var appearances []common.Hash
+	seenShards := make(map[string]struct{})
+	seenTxs := make(map[common.Hash]struct{})
+	for block := fromBlock; block <= toBlock; block++ {
+		shardPath := path.Join(cwd, "addr_index", strconv.FormatUint(block/vm.AppearanceIndexPerFolder, 10), strconv.FormatUint(block/vm.AppearanceIndexPerFile, 10)+".log")
+		if _, ok := seenShards[shardPath]; ok {
+			continue
+		}
+		seenShards[shardPath] = struct{}{}
+
+		txs, err := readShard(shardPath, addr, fromBlock, toBlock)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txs {
+			if _, ok := seenTxs[tx]; ok {
+				continue
+			}
+			seenTxs[tx] = struct{}{}
+			appearances = append(appearances, tx)
+		}
+	}
+	return appearances, nil
+}
+
+func readShard(shardPath string, addr common.Address, fromBlock, toBlock uint64) ([]common.Hash, error) {
+	file, err := os.Open(shardPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var txs []common.Hash
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry appearanceEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("decode addr_index entry in %s failed: %w", shardPath, err)
+		}
+		if entry.Address == addr && entry.BlockNumber >= fromBlock && entry.BlockNumber <= toBlock {
+			txs = append(txs, entry.TxHash)
+		}
+	}
+	return txs, scanner.Err()
+}
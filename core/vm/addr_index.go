@@ -0,0 +1,119 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// AppearanceIndexPerFolder and AppearanceIndexPerFile are the sharding
+// factors that readers of the index, such as core/addrindex, locate
+// addr_index files by. NewAppearanceIndexer buckets by the perFolder and
+// perFile it is given, so an index written with other values is not found
+// by those readers.
+const (
+	AppearanceIndexPerFolder = 100_000
+	AppearanceIndexPerFile   = 1_000
+)
+
+// AppearanceEntry is a single (address, transaction) appearance record emitted
+// to an addr_index shard.
+type AppearanceEntry struct {
+	Address     common.Address `json:"address"`
+	TxHash      common.Hash    `json:"txHash"`
+	BlockNumber uint64         `json:"blockNumber"`
+}
+
+// AppearanceIndexer builds an Otterscan-style address->txhash appearance index
+// alongside parity traces. It is driven by ParityLogger.CaptureEnter for every
+// address that takes part in a call - sender, recipient, created contract,
+// SELFDESTRUCT beneficiary, and internal call targets - and shards its output
+// the same way ParityLogger shards trace files, under addr_index/ instead of
+// traces/. Attach one AppearanceIndexer to every ParityLogger tracing a given
+// block via ParityLogger.SetAppearanceIndex, so that duplicate (address,
+// txHash) pairs seen across the block's transactions are coalesced. Block
+// tracers commonly run one goroutine per transaction, so Record and Close are
+// safe to call concurrently from every logger sharing an indexer.
+type AppearanceIndexer struct {
+	blockNumber uint64
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	file    *os.File
+	seen    map[common.Address]map[common.Hash]struct{}
+}
+
+// NewAppearanceIndexer creates an appearance indexer for the shard that
+// blockNumber falls into, mirroring the
+// traces/<blockNumber/perFolder>/<blockNumber/perFile>.log layout used by
+// NewParityLogger, but rooted at addr_index/.
+func NewAppearanceIndexer(blockNumber uint64, perFolder, perFile uint64) (*AppearanceIndexer, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("get current work dir failed: %w", err)
+	}
+
+	logPath := path.Join(cwd, "addr_index", strconv.FormatUint(blockNumber/perFolder, 10), strconv.FormatUint(blockNumber/perFile, 10)+".log")
+	if err := os.MkdirAll(path.Dir(logPath), 0755); err != nil {
+		return nil, fmt.Errorf("mkdir for all parents [%v] failed: %w", path.Dir(logPath), err)
+	}
+
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create file %s failed: %w", logPath, err)
+	}
+
+	return &AppearanceIndexer{
+		blockNumber: blockNumber,
+		encoder:     json.NewEncoder(file),
+		file:        file,
+		seen:        make(map[common.Address]map[common.Hash]struct{}),
+	}, nil
+}
+
+// Record appends an (addr, txHash) appearance, skipping it if it was already
+// recorded for this block. Safe for concurrent use.
+func (idx *AppearanceIndexer) Record(addr common.Address, txHash common.Hash) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	txs, ok := idx.seen[addr]
+	if !ok {
+		txs = make(map[common.Hash]struct{})
+		idx.seen[addr] = txs
+	}
+	if _, ok := txs[txHash]; ok {
+		return
+	}
+	txs[txHash] = struct{}{}
+	idx.encoder.Encode(AppearanceEntry{Address: addr, TxHash: txHash, BlockNumber: idx.blockNumber})
+}
+
+// Close is safe for concurrent use, including concurrently with Record.
+func (idx *AppearanceIndexer) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.file.Close()
+}
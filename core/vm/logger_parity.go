@@ -19,7 +19,7 @@ package vm
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/ethereum/go-ethereum/common/hexutil"
+	"io"
 	"math/big"
 	"os"
 	"path"
@@ -28,6 +28,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 type ParityTraceItemAction struct {
@@ -37,11 +38,29 @@ type ParityTraceItemAction struct {
 	Gas      hexutil.Uint64 `json:"gas"`
 	Input    hexutil.Bytes  `json:"input"`
 	Value    hexutil.Bytes  `json:"value"`
+	// MethodID is the 4-byte function selector dispatched for this call, populated
+	// only when ParityLoggerConfig.EnableOpcodeSummary is set.
+	MethodID hexutil.Bytes `json:"methodID,omitempty"`
+}
+
+// StorageDiffEntry records the before/after value of a single storage slot touched
+// by a call via SSTORE.
+type StorageDiffEntry struct {
+	Slot   common.Hash `json:"slot"`
+	Before common.Hash `json:"before"`
+	After  common.Hash `json:"after"`
 }
 
 type ParityTraceItemResult struct {
 	GasUsed hexutil.Uint64 `json:"gasUsed"`
 	Output  hexutil.Bytes  `json:"output"`
+	// StorageDiff and SelfDestructTo are only populated when
+	// ParityLoggerConfig.EnableOpcodeSummary is set.
+	StorageDiff    []StorageDiffEntry `json:"storageDiff,omitempty"`
+	SelfDestructTo *common.Address    `json:"selfDestructTo,omitempty"`
+	// PrecompileName is set when PrecompilePolicy is PrecompileSummarize and this
+	// call invoked a precompile, e.g. "ecrecover" or "kzgPointEvaluation".
+	PrecompileName string `json:"precompileName,omitempty"`
 }
 
 type ParityTraceItem struct {
@@ -66,18 +85,68 @@ type ParityLogContext struct {
 	TxHash      common.Hash
 }
 
+// ParityLoggerConfig toggles optional, more expensive per-opcode bookkeeping in
+// ParityLogger.
+type ParityLoggerConfig struct {
+	// EnableOpcodeSummary makes CaptureState collect the dispatched method ID,
+	// SSTORE storage diffs, and SELFDESTRUCT beneficiaries for each call. It is
+	// off by default since it adds bookkeeping to every opcode.
+	EnableOpcodeSummary bool
+	// PrecompilePolicy controls what happens to a subcall into an active
+	// precompile. The zero value is PrecompileDrop, matching the historical
+	// behavior of silently discarding these subcalls.
+	PrecompilePolicy PrecompilePolicy
+	// FsyncOnClose fsyncs the underlying trace file before closing it, so
+	// indexers tailing the file observe a crash-consistent write.
+	FsyncOnClose bool
+}
+
+// PrecompilePolicy selects how ParityLogger handles a subcall into an active
+// precompile.
+type PrecompilePolicy int
+
+const (
+	// PrecompileDrop removes the subcall entirely, as if it never happened.
+	PrecompileDrop PrecompilePolicy = iota
+	// PrecompileKeep retains the subcall as an ordinary trace item.
+	PrecompileKeep
+	// PrecompileSummarize retains the subcall, tags its Action.CallType as
+	// "precompile", and sets Result.PrecompileName to the precompile's name.
+	PrecompileSummarize
+)
+
 type ParityLogger struct {
 	context           *ParityLogContext
+	config            ParityLoggerConfig
 	encoder           *json.Encoder
+	traceEncoder      TraceEncoder
 	activePrecompiles []common.Address
-	file              *os.File
+	out               io.Writer
+	env               *EVM
+	index             *AppearanceIndexer
 	stack             []*ParityTraceItem
 	items             []*ParityTraceItem
+	stream            bool
+	traceSeq          int
 }
 
-// NewParityLogger creates a new EVM tracer that prints execution steps as parity trace format
-// into the provided stream.
-func NewParityLogger(ctx *ParityLogContext, blockNumber uint64, perFolder, perFile uint64) (*ParityLogger, error) {
+// SetAppearanceIndex attaches an AppearanceIndexer that records, for every
+// call entered, the addresses that took part in it against the current
+// transaction hash. Pass nil to detach.
+func (l *ParityLogger) SetAppearanceIndex(index *AppearanceIndexer) {
+	l.index = index
+}
+
+// NewParityLogger creates a new EVM tracer that appends the trace items of each transaction to
+// traces/<blockNumber/perFolder>/<blockNumber/perFile>.log under the current working directory,
+// encoded as the given TraceFormat ("parity", "callTracer", or "flatCallTracer"; an
+// empty format defaults to "parity"). A nil config disables all optional opcode-level summaries.
+func NewParityLogger(ctx *ParityLogContext, config *ParityLoggerConfig, format TraceFormat, blockNumber uint64, perFolder, perFile uint64) (*ParityLogger, error) {
+	traceEncoder, err := resolveTraceEncoder(format)
+	if err != nil {
+		return nil, err
+	}
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, fmt.Errorf("get current work dir failed: %w", err)
@@ -94,7 +163,37 @@ func NewParityLogger(ctx *ParityLogContext, blockNumber uint64, perFolder, perFi
 		return nil, fmt.Errorf("create file %s failed: %w", logPath, err)
 	}
 
-	l := &ParityLogger{context: ctx, encoder: json.NewEncoder(file), file: file}
+	l := &ParityLogger{context: ctx, encoder: json.NewEncoder(file), traceEncoder: traceEncoder, out: file}
+	if config != nil {
+		l.config = *config
+	}
+	if l.context == nil {
+		l.context = &ParityLogContext{}
+	}
+	return l, nil
+}
+
+// NewParityStreamLogger creates a new EVM tracer that, unlike NewParityLogger, does not buffer
+// the full set of trace items for a transaction in memory. Instead, each item is encoded and
+// written to w as soon as it completes (on CaptureExit), so callers can pipe traces to any
+// io.Writer - a Kafka producer, a gRPC stream, a rotating file sink - without holding O(N) items
+// for deep call trees and without waiting for the transaction to finish. If w also implements
+// io.Closer, Close will close it. A nil config disables all optional opcode-level summaries.
+// format must name a streamable encoding ("parity" or "flatCallTracer"); "callTracer" needs the
+// complete call tree and returns ErrFormatNotStreamable.
+func NewParityStreamLogger(ctx *ParityLogContext, config *ParityLoggerConfig, format TraceFormat, w io.Writer) (*ParityLogger, error) {
+	traceEncoder, err := resolveTraceEncoder(format)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatCallTracer {
+		return nil, ErrFormatNotStreamable
+	}
+
+	l := &ParityLogger{context: ctx, encoder: json.NewEncoder(w), traceEncoder: traceEncoder, out: w, stream: true}
+	if config != nil {
+		l.config = *config
+	}
 	if l.context == nil {
 		l.context = &ParityLogContext{}
 	}
@@ -102,14 +201,26 @@ func NewParityLogger(ctx *ParityLogContext, blockNumber uint64, perFolder, perFi
 }
 
 func (l *ParityLogger) Close() error {
-	return l.file.Close()
+	if l.config.FsyncOnClose {
+		if f, ok := l.out.(*os.File); ok {
+			if err := f.Sync(); err != nil {
+				return fmt.Errorf("fsync trace file failed: %w", err)
+			}
+		}
+	}
+	if c, ok := l.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
 }
 
 func (l *ParityLogger) CaptureStart(env *EVM, from, to common.Address, create bool, input []byte, gas uint64, value *big.Int) {
 	rules := env.ChainConfig().Rules(env.Context.BlockNumber)
+	l.env = env
 	l.activePrecompiles = ActivePrecompiles(rules)
 	l.stack = make([]*ParityTraceItem, 0, 20)
 	l.items = make([]*ParityTraceItem, 0, 20)
+	l.traceSeq = 0
 	if create {
 		l.CaptureEnter(CREATE, from, to, input, gas, value)
 	} else {
@@ -120,13 +231,53 @@ func (l *ParityLogger) CaptureStart(env *EVM, from, to common.Address, create bo
 func (l *ParityLogger) CaptureFault(uint64, OpCode, uint64, uint64, *ScopeContext, int, error) {
 }
 
-// CaptureState outputs state information on the logger.
+// CaptureState collects per-call opcode summaries - the dispatched method ID,
+// SSTORE storage diffs, and SELFDESTRUCT beneficiaries - when
+// ParityLoggerConfig.EnableOpcodeSummary is enabled. It is otherwise a no-op,
+// except that a SELFDESTRUCT beneficiary is always recorded into the
+// appearance index: the index's address coverage must not depend on an
+// opt-in, pricier summary flag.
 func (l *ParityLogger) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *ScopeContext, rData []byte, depth int, err error) {
+	if op == SELFDESTRUCT {
+		beneficiary := common.Address(scope.Stack.Back(0).Bytes20())
+		if l.index != nil {
+			l.index.Record(beneficiary, l.context.TxHash)
+		}
+		if l.config.EnableOpcodeSummary {
+			l.stack[len(l.stack)-1].Result.SelfDestructTo = &beneficiary
+		}
+	}
+
+	if !l.config.EnableOpcodeSummary {
+		return
+	}
+	current := l.stack[len(l.stack)-1]
+
+	if pc == 0 && current.Type == "call" && len(current.Action.Input) >= 4 {
+		current.Action.MethodID = append([]byte{}, current.Action.Input[:4]...)
+	}
+
+	if op == SSTORE {
+		slot := common.Hash(scope.Stack.Back(0).Bytes32())
+		after := common.Hash(scope.Stack.Back(1).Bytes32())
+		before := l.env.StateDB.GetState(scope.Contract.Address(), slot)
+		current.Result.StorageDiff = append(current.Result.StorageDiff, StorageDiffEntry{
+			Slot:   slot,
+			Before: before,
+			After:  after,
+		})
+	}
 }
 
 // CaptureEnd is triggered at end of execution.
 func (l *ParityLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) {
 	l.CaptureExit(output, gasUsed, err)
+	if l.stream {
+		// Items were already encoded as they completed in CaptureExit, so there is
+		// nothing left to flush. TransactionLastTrace cannot be retro-fitted onto
+		// already-written items without buffering, so streamed items leave it unset.
+		return
+	}
 	itemsSize := len(l.items)
 	for no, item := range l.items {
 		item.TransactionTraceID = no
@@ -135,8 +286,8 @@ func (l *ParityLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration
 		} else {
 			item.TransactionLastTrace = 0
 		}
-		l.encoder.Encode(item)
 	}
+	l.traceEncoder.EncodeFinal(l.encoder, l.items)
 }
 
 func getTraceType(typ OpCode) string {
@@ -183,8 +334,15 @@ func (l *ParityLogger) CaptureEnter(typ OpCode, from common.Address, to common.A
 		newItem.Action.Value = value.Bytes()
 	}
 
-	l.items = append(l.items, newItem)
+	if !l.stream {
+		l.items = append(l.items, newItem)
+	}
 	l.stack = append(l.stack, newItem)
+
+	if l.index != nil {
+		l.index.Record(from, l.context.TxHash)
+		l.index.Record(to, l.context.TxHash)
+	}
 }
 
 func (l *ParityLogger) isPrecompiled(addr common.Address) bool {
@@ -205,12 +363,32 @@ func (l *ParityLogger) CaptureExit(output []byte, gasUsed uint64, err error) {
 	}
 	l.stack = l.stack[0 : len(l.stack)-1]
 
-	// remove precompiled call
 	if l.isPrecompiled(current.Action.To) {
-		s := len(l.items)
-		l.items = l.items[0 : s-1]
-		if s > 1 {
-			l.items[s-2].Subtraces -= 1
+		switch l.config.PrecompilePolicy {
+		case PrecompileKeep:
+			// Retained below as an ordinary trace item.
+		case PrecompileSummarize:
+			current.Action.CallType = "precompile"
+			current.Result.PrecompileName = precompileName(current.Action.To)
+		default:
+			// PrecompileDrop: discard the subcall entirely.
+			if l.stream {
+				if len(l.stack) > 0 {
+					l.stack[len(l.stack)-1].Subtraces -= 1
+				}
+				return
+			}
+			l.items = l.items[0 : len(l.items)-1]
+			if len(l.stack) > 0 {
+				l.stack[len(l.stack)-1].Subtraces -= 1
+			}
+			return
 		}
 	}
+
+	if l.stream {
+		current.TransactionTraceID = l.traceSeq
+		l.traceSeq++
+		l.traceEncoder.EncodeItem(l.encoder, current)
+	}
 }
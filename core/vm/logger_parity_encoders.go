@@ -0,0 +1,211 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// TraceFormat selects the on-disk/on-wire shape ParityLogger encodes trace
+// items into.
+type TraceFormat string
+
+const (
+	// FormatParity is the original flat parity_trace-style format: one JSON
+	// object per call, in preorder (in completion order, children before
+	// their parent, when written by NewParityStreamLogger).
+	FormatParity TraceFormat = "parity"
+	// FormatCallTracer mirrors geth's built-in callTracer: a single nested
+	// JSON object per transaction, with child calls under "calls".
+	FormatCallTracer TraceFormat = "callTracer"
+	// FormatFlatCallTracer mirrors Erigon's flatCallTracer: the same flat,
+	// one-object-per-call layout and hex-encoded gas fields as FormatParity,
+	// but with callTracer's upper-cased type and without the
+	// transactionTraceID/transactionLastTrace fields.
+	FormatFlatCallTracer TraceFormat = "flatCallTracer"
+)
+
+// ErrFormatNotStreamable is returned by NewParityStreamLogger when asked for a
+// format that needs the complete call tree before it can encode anything.
+var ErrFormatNotStreamable = errors.New("vm: trace format cannot be streamed per item")
+
+// TraceEncoder renders the ParityTraceItems collected by a ParityLogger into a
+// specific wire format. The stack/items bookkeeping in ParityLogger is shared
+// across all formats; only the final encoding step differs.
+type TraceEncoder interface {
+	// EncodeItem writes a single item as soon as it completes. Used by
+	// streaming loggers. Formats that cannot be streamed return
+	// ErrFormatNotStreamable.
+	EncodeItem(enc *json.Encoder, item *ParityTraceItem) error
+	// EncodeFinal writes every item collected by a non-streaming logger, in
+	// original (preorder) order, once the top-level call has returned.
+	EncodeFinal(enc *json.Encoder, items []*ParityTraceItem) error
+}
+
+// resolveTraceEncoder maps a TraceFormat onto its TraceEncoder. An empty
+// format defaults to FormatParity for backwards compatibility.
+func resolveTraceEncoder(format TraceFormat) (TraceEncoder, error) {
+	switch format {
+	case "", FormatParity:
+		return parityEncoder{}, nil
+	case FormatCallTracer:
+		return callTracerEncoder{}, nil
+	case FormatFlatCallTracer:
+		return flatCallTracerEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("vm: unknown trace format %q", format)
+	}
+}
+
+// parityEncoder is the original flat parity_trace format.
+type parityEncoder struct{}
+
+func (parityEncoder) EncodeItem(enc *json.Encoder, item *ParityTraceItem) error {
+	return enc.Encode(item)
+}
+
+func (parityEncoder) EncodeFinal(enc *json.Encoder, items []*ParityTraceItem) error {
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flatCallTracerItem is an Erigon flatCallTracer entry: the same flat shape as
+// ParityTraceItem, but with the upper-cased call type as its type and without
+// TransactionTraceID/TransactionLastTrace.
+type flatCallTracerItem struct {
+	Type                string                `json:"type"`
+	Action              ParityTraceItemAction `json:"action"`
+	Result              ParityTraceItemResult `json:"result"`
+	Subtraces           int                   `json:"subtraces"`
+	TraceAddress        []int                 `json:"traceAddress"`
+	Error               string                `json:"error,omitempty"`
+	BlockHash           common.Hash           `json:"blockHash"`
+	BlockNumber         uint64                `json:"blockNumber"`
+	TransactionHash     common.Hash           `json:"transactionHash"`
+	TransactionPosition int                   `json:"transactionPosition"`
+}
+
+func newFlatCallTracerItem(item *ParityTraceItem) flatCallTracerItem {
+	return flatCallTracerItem{
+		Type:                strings.ToUpper(item.Action.CallType),
+		Action:              item.Action,
+		Result:              item.Result,
+		Subtraces:           item.Subtraces,
+		TraceAddress:        item.TraceAddress,
+		Error:               item.Error,
+		BlockHash:           item.BlockHash,
+		BlockNumber:         item.BlockNumber,
+		TransactionHash:     item.TransactionHash,
+		TransactionPosition: item.TransactionPosition,
+	}
+}
+
+type flatCallTracerEncoder struct{}
+
+func (flatCallTracerEncoder) EncodeItem(enc *json.Encoder, item *ParityTraceItem) error {
+	out := newFlatCallTracerItem(item)
+	return enc.Encode(&out)
+}
+
+func (e flatCallTracerEncoder) EncodeFinal(enc *json.Encoder, items []*ParityTraceItem) error {
+	for _, item := range items {
+		if err := e.EncodeItem(enc, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callTracerCall is a single node of geth's nested callTracer output.
+type callTracerCall struct {
+	Type    string            `json:"type"`
+	From    common.Address    `json:"from"`
+	To      common.Address    `json:"to"`
+	Value   hexutil.Bytes     `json:"value,omitempty"`
+	Gas     hexutil.Uint64    `json:"gas"`
+	GasUsed hexutil.Uint64    `json:"gasUsed"`
+	Input   hexutil.Bytes     `json:"input"`
+	Output  hexutil.Bytes     `json:"output,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Calls   []*callTracerCall `json:"calls,omitempty"`
+}
+
+type callTracerEncoder struct{}
+
+func (callTracerEncoder) EncodeItem(*json.Encoder, *ParityTraceItem) error {
+	return ErrFormatNotStreamable
+}
+
+func (callTracerEncoder) EncodeFinal(enc *json.Encoder, items []*ParityTraceItem) error {
+	root := buildCallTree(items)
+	if root == nil {
+		return nil
+	}
+	return enc.Encode(root)
+}
+
+func newCallTracerCall(item *ParityTraceItem) *callTracerCall {
+	return &callTracerCall{
+		Type:    strings.ToUpper(item.Action.CallType),
+		From:    item.Action.From,
+		To:      item.Action.To,
+		Value:   item.Action.Value,
+		Gas:     item.Action.Gas,
+		GasUsed: item.Result.GasUsed,
+		Input:   item.Action.Input,
+		Output:  item.Result.Output,
+		Error:   item.Error,
+	}
+}
+
+// buildCallTree reassembles the call tree from the flat, preorder item list
+// using each item's TraceAddress to find its parent.
+func buildCallTree(items []*ParityTraceItem) *callTracerCall {
+	if len(items) == 0 {
+		return nil
+	}
+	nodes := make([]*callTracerCall, len(items))
+	byAddr := make(map[string]*callTracerCall, len(items))
+	for i, item := range items {
+		nodes[i] = newCallTracerCall(item)
+		byAddr[traceAddressKey(item.TraceAddress)] = nodes[i]
+	}
+	for i, item := range items {
+		if len(item.TraceAddress) == 0 {
+			continue // root
+		}
+		parent, ok := byAddr[traceAddressKey(item.TraceAddress[:len(item.TraceAddress)-1])]
+		if ok {
+			parent.Calls = append(parent.Calls, nodes[i])
+		}
+	}
+	return nodes[0]
+}
+
+func traceAddressKey(addr []int) string {
+	return fmt.Sprint(addr)
+}
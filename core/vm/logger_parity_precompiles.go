@@ -0,0 +1,53 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import "github.com/ethereum/go-ethereum/common"
+
+// precompileNames maps every precompile address geth may have active,
+// including the EIP-2537 (BLS12-381) and EIP-4844 (point evaluation)
+// additions, to the human-readable name used for
+// ParityTraceItemResult.PrecompileName under PrecompileSummarize.
+var precompileNames = map[common.Address]string{
+	common.BytesToAddress([]byte{1}):  "ecrecover",
+	common.BytesToAddress([]byte{2}):  "sha256",
+	common.BytesToAddress([]byte{3}):  "ripemd160",
+	common.BytesToAddress([]byte{4}):  "identity",
+	common.BytesToAddress([]byte{5}):  "modexp",
+	common.BytesToAddress([]byte{6}):  "bn256Add",
+	common.BytesToAddress([]byte{7}):  "bn256ScalarMul",
+	common.BytesToAddress([]byte{8}):  "bn256Pairing",
+	common.BytesToAddress([]byte{9}):  "blake2f",
+	common.BytesToAddress([]byte{10}): "kzgPointEvaluation",
+	common.BytesToAddress([]byte{11}): "blsG1Add",
+	common.BytesToAddress([]byte{12}): "blsG1MultiExp",
+	common.BytesToAddress([]byte{13}): "blsG2Add",
+	common.BytesToAddress([]byte{14}): "blsG2MultiExp",
+	common.BytesToAddress([]byte{15}): "blsPairing",
+	common.BytesToAddress([]byte{16}): "blsMapG1",
+	common.BytesToAddress([]byte{17}): "blsMapG2",
+}
+
+// precompileName returns the human-readable name for a precompile address, or
+// "unknown" if addr isn't one of the addresses geth has ever assigned to a
+// precompile.
+func precompileName(addr common.Address) string {
+	if name, ok := precompileNames[addr]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newPrecompileExitLogger builds a ParityLogger with a parent call and a
+// pending subcall into precompileAddr already on the stack, as CaptureEnter
+// would leave it, so CaptureExit's PrecompilePolicy handling can be exercised
+// without spinning up a full EVM.
+func newPrecompileExitLogger(policy PrecompilePolicy, precompileAddr common.Address) *ParityLogger {
+	parent := &ParityTraceItem{Subtraces: 1}
+	child := &ParityTraceItem{
+		Action: ParityTraceItemAction{To: precompileAddr},
+	}
+	return &ParityLogger{
+		config:            ParityLoggerConfig{PrecompilePolicy: policy},
+		activePrecompiles: []common.Address{precompileAddr},
+		stack:             []*ParityTraceItem{parent, child},
+		items:             []*ParityTraceItem{parent, child},
+	}
+}
+
+func TestCaptureExitPrecompileDrop(t *testing.T) {
+	precompile := common.BytesToAddress([]byte{1})
+	l := newPrecompileExitLogger(PrecompileDrop, precompile)
+
+	l.CaptureExit(nil, 0, nil)
+
+	if len(l.items) != 1 {
+		t.Fatalf("len(items) = %d, want 1 (precompile subcall dropped)", len(l.items))
+	}
+	if l.items[0].Subtraces != 0 {
+		t.Fatalf("parent.Subtraces = %d, want 0 after dropping its only subcall", l.items[0].Subtraces)
+	}
+}
+
+func TestCaptureExitPrecompileKeep(t *testing.T) {
+	precompile := common.BytesToAddress([]byte{1})
+	l := newPrecompileExitLogger(PrecompileKeep, precompile)
+
+	l.CaptureExit(nil, 0, nil)
+
+	if len(l.items) != 2 {
+		t.Fatalf("len(items) = %d, want 2 (precompile subcall kept)", len(l.items))
+	}
+	child := l.items[1]
+	if child.Action.CallType != "" {
+		t.Fatalf("Action.CallType = %q, want unchanged (empty) under PrecompileKeep", child.Action.CallType)
+	}
+	if child.Result.PrecompileName != "" {
+		t.Fatalf("Result.PrecompileName = %q, want empty under PrecompileKeep", child.Result.PrecompileName)
+	}
+}
+
+func TestCaptureExitPrecompileSummarize(t *testing.T) {
+	precompile := common.BytesToAddress([]byte{1})
+	l := newPrecompileExitLogger(PrecompileSummarize, precompile)
+
+	l.CaptureExit(nil, 0, nil)
+
+	if len(l.items) != 2 {
+		t.Fatalf("len(items) = %d, want 2 (precompile subcall kept)", len(l.items))
+	}
+	child := l.items[1]
+	if child.Action.CallType != "precompile" {
+		t.Fatalf("Action.CallType = %q, want %q", child.Action.CallType, "precompile")
+	}
+	if want := "ecrecover"; child.Result.PrecompileName != want {
+		t.Fatalf("Result.PrecompileName = %q, want %q", child.Result.PrecompileName, want)
+	}
+}
+
+func TestCaptureExitNonPrecompileIgnoresPolicy(t *testing.T) {
+	precompile := common.BytesToAddress([]byte{1})
+	nonPrecompile := common.BytesToAddress([]byte{0xAA})
+	l := newPrecompileExitLogger(PrecompileDrop, precompile)
+	l.items[1].Action.To = nonPrecompile
+	l.stack[1].Action.To = nonPrecompile
+
+	l.CaptureExit(nil, 0, nil)
+
+	if len(l.items) != 2 {
+		t.Fatalf("len(items) = %d, want 2 (non-precompile subcall must survive PrecompileDrop)", len(l.items))
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PruneNonCanonical walks the traces directory and, for every shard file,
+// drops the trace items whose BlockNumber is covered by canonicalHashes but
+// whose BlockHash no longer matches - i.e. items left behind by a block that
+// was since reorged out. Because a shard can hold the traces of several
+// blocks (whenever perFile > 1), files are rewritten in place rather than
+// removed outright, so canonical blocks sharing the shard are preserved; a
+// shard left with no items after pruning is removed entirely.
+//
+// Only flat, one-JSON-object-per-line shards (FormatParity, FormatFlatCallTracer)
+// carry a per-item BlockHash and can be pruned this way; a FormatCallTracer
+// shard - a single nested object per transaction with no such field - is left
+// untouched.
+func PruneNonCanonical(canonicalHashes map[uint64]common.Hash) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("get current work dir failed: %w", err)
+	}
+	tracesDir := path.Join(cwd, "traces")
+
+	err = filepath.WalkDir(tracesDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".log") {
+			return nil
+		}
+		return pruneShardFile(p, canonicalHashes)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// pruneShardFile rewrites a single shard file, dropping lines for blocks
+// canonicalHashes tracks but no longer matches. Shards that aren't flat
+// per-item JSON lines (e.g. a callTracer shard) are left untouched.
+func pruneShardFile(shardPath string, canonicalHashes map[uint64]common.Hash) error {
+	data, err := os.ReadFile(shardPath)
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	kept := make([][]byte, 0, len(lines))
+	changed := false
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		blockNumber, blockHash, ok, err := flatShardLineFields(line)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Not a flat per-item record (e.g. a callTracer shard); leave the
+			// file untouched rather than risk corrupting a format we can't
+			// interpret line-by-line.
+			return nil
+		}
+
+		canonicalHash, tracked := canonicalHashes[blockNumber]
+		if tracked && canonicalHash != blockHash {
+			changed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if !changed {
+		return nil
+	}
+	if len(kept) == 0 {
+		return os.Remove(shardPath)
+	}
+
+	out := bytes.Join(kept, []byte("\n"))
+	out = append(out, '\n')
+	return os.WriteFile(shardPath, out, 0755)
+}
+
+// flatShardLineFields extracts the blockNumber/blockHash of a single flat
+// shard line. ok is false if the line has no "blockNumber" or "blockHash"
+// key at all - e.g. a FormatCallTracer line - rather than merely decoding
+// them as their zero values, which json.Unmarshal would otherwise do
+// silently for any line missing those keys (including block 0, a real
+// concern on chains that reorg at genesis).
+func flatShardLineFields(line []byte) (blockNumber uint64, blockHash common.Hash, ok bool, err error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return 0, common.Hash{}, false, fmt.Errorf("decode shard line failed: %w", err)
+	}
+	blockNumberRaw, hasBlockNumber := raw["blockNumber"]
+	blockHashRaw, hasBlockHash := raw["blockHash"]
+	if !hasBlockNumber || !hasBlockHash {
+		return 0, common.Hash{}, false, nil
+	}
+
+	if err := json.Unmarshal(blockNumberRaw, &blockNumber); err != nil {
+		return 0, common.Hash{}, false, fmt.Errorf("decode blockNumber failed: %w", err)
+	}
+	if err := json.Unmarshal(blockHashRaw, &blockHash); err != nil {
+		return 0, common.Hash{}, false, fmt.Errorf("decode blockHash failed: %w", err)
+	}
+	return blockNumber, blockHash, true, nil
+}
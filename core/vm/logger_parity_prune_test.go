@@ -0,0 +1,151 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPruneShardFileDropsReorgedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	shardPath := path.Join(dir, "0.log")
+
+	canonicalA := common.HexToHash("0x1")
+	staleA := common.HexToHash("0x2")
+	canonicalB := common.HexToHash("0x3")
+
+	lines := []string{
+		`{"blockNumber":1,"blockHash":"` + staleA.Hex() + `"}`,
+		`{"blockNumber":2,"blockHash":"` + canonicalB.Hex() + `"}`,
+	}
+	if err := os.WriteFile(shardPath, []byte(lines[0]+"\n"+lines[1]+"\n"), 0644); err != nil {
+		t.Fatalf("write shard failed: %v", err)
+	}
+
+	err := pruneShardFile(shardPath, map[uint64]common.Hash{1: canonicalA, 2: canonicalB})
+	if err != nil {
+		t.Fatalf("pruneShardFile failed: %v", err)
+	}
+
+	got, err := os.ReadFile(shardPath)
+	if err != nil {
+		t.Fatalf("read pruned shard failed: %v", err)
+	}
+	want := lines[1] + "\n"
+	if string(got) != want {
+		t.Fatalf("pruned shard = %q, want %q", got, want)
+	}
+}
+
+func TestPruneShardFileRemovesEmptyShard(t *testing.T) {
+	dir := t.TempDir()
+	shardPath := path.Join(dir, "0.log")
+
+	stale := common.HexToHash("0x2")
+	canonical := common.HexToHash("0x1")
+	if err := os.WriteFile(shardPath, []byte(`{"blockNumber":1,"blockHash":"`+stale.Hex()+`"}`+"\n"), 0644); err != nil {
+		t.Fatalf("write shard failed: %v", err)
+	}
+
+	if err := pruneShardFile(shardPath, map[uint64]common.Hash{1: canonical}); err != nil {
+		t.Fatalf("pruneShardFile failed: %v", err)
+	}
+
+	if _, err := os.Stat(shardPath); !os.IsNotExist(err) {
+		t.Fatalf("expected shard to be removed, stat err = %v", err)
+	}
+}
+
+func TestPruneShardFileLeavesCallTracerShardUntouched(t *testing.T) {
+	dir := t.TempDir()
+	shardPath := path.Join(dir, "0.log")
+
+	// A FormatCallTracer line has no blockNumber/blockHash keys at all. Block 0
+	// being tracked as canonical must not cause it to be mistaken for a stale
+	// flat record at block 0 (the bug this test guards against).
+	line := `{"type":"CALL","from":"0x0000000000000000000000000000000000000001","to":"0x0000000000000000000000000000000000000002"}`
+	if err := os.WriteFile(shardPath, []byte(line+"\n"), 0644); err != nil {
+		t.Fatalf("write shard failed: %v", err)
+	}
+
+	err := pruneShardFile(shardPath, map[uint64]common.Hash{0: common.HexToHash("0x1")})
+	if err != nil {
+		t.Fatalf("pruneShardFile failed: %v", err)
+	}
+
+	got, err := os.ReadFile(shardPath)
+	if err != nil {
+		t.Fatalf("read shard failed: %v", err)
+	}
+	if string(got) != line+"\n" {
+		t.Fatalf("callTracer shard was modified: got %q, want %q", got, line+"\n")
+	}
+}
+
+func TestPruneNonCanonicalWalksTracesDir(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd failed: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir failed: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	shardDir := path.Join(dir, "traces", "0")
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	shardPath := filepath.Join(shardDir, "0.log")
+
+	canonical := common.HexToHash("0x1")
+	stale := common.HexToHash("0x2")
+	lines := `{"blockNumber":1,"blockHash":"` + stale.Hex() + `"}` + "\n"
+	if err := os.WriteFile(shardPath, []byte(lines), 0644); err != nil {
+		t.Fatalf("write shard failed: %v", err)
+	}
+
+	if err := PruneNonCanonical(map[uint64]common.Hash{1: canonical}); err != nil {
+		t.Fatalf("PruneNonCanonical failed: %v", err)
+	}
+
+	if _, err := os.Stat(shardPath); !os.IsNotExist(err) {
+		t.Fatalf("expected shard with only the reorged block to be removed, stat err = %v", err)
+	}
+}
+
+func TestPruneNonCanonicalNoTracesDir(t *testing.T) {
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getwd failed: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("chdir failed: %v", err)
+	}
+	defer os.Chdir(cwd)
+
+	if err := PruneNonCanonical(map[uint64]common.Hash{1: common.HexToHash("0x1")}); err != nil {
+		t.Fatalf("PruneNonCanonical on missing traces dir should be a no-op, got: %v", err)
+	}
+}